@@ -0,0 +1,197 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//nolint:forcetypeassert,nonamedreturns
+package lru
+
+import (
+	"container/list"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConcurrentLRU is a thread-safe LRU cache. It shards its keyspace across a
+// fixed number of independently locked segments, so that unrelated keys
+// don't contend on the same mutex, and exposes the same surface as
+// LRUWithEvict.
+type ConcurrentLRU[K comparable, V any] struct {
+	metrics *concurrentMetrics
+	closer  func() error
+
+	shards []*lruShard[K, V]
+}
+
+// lruShard is a single mutex-guarded, fixed-capacity segment of a
+// ConcurrentLRU.
+type lruShard[K comparable, V any] struct {
+	mu sync.Mutex
+
+	id         string
+	maxEntries int
+	onEvicted  func(K, V)
+
+	evictList *list.List
+	items     map[K]*list.Element
+}
+
+func newLRUShard[K comparable, V any](id string, maxEntries int, onEvicted func(K, V)) *lruShard[K, V] {
+	return &lruShard[K, V]{
+		id:         id,
+		maxEntries: maxEntries,
+		onEvicted:  onEvicted,
+		evictList:  list.New(),
+		items:      make(map[K]*list.Element),
+	}
+}
+
+// NewConcurrentLRU returns a new thread-safe cache with the provided
+// maximum items count, sharded across shards independently locked
+// segments. A shards value of zero defaults to runtime.GOMAXPROCS(0).
+//
+// maxEntries is a total across all shards, not a per-shard count: each
+// shard holds at most maxEntries/shards entries, rounded up to 1. If that
+// rounding would let shards*1 exceed maxEntries (a small maxEntries on a
+// high-core-count host), shards is clamped down to maxEntries so the
+// requested total is still an upper bound on the cache's size.
+func NewConcurrentLRU[K comparable, V any](reg prometheus.Registerer, maxEntries, shards int, onEvicted func(K, V)) *ConcurrentLRU[K, V] {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	if maxEntries > 0 && shards > maxEntries {
+		shards = maxEntries
+	}
+
+	perShard := maxEntries / shards
+	if maxEntries != 0 && perShard == 0 {
+		perShard = 1
+	}
+
+	m := newConcurrentMetrics(reg)
+	c := &ConcurrentLRU[K, V]{
+		metrics: m,
+		closer:  m.unregister,
+		shards:  make([]*lruShard[K, V], shards),
+	}
+	for i := range c.shards {
+		c.shards[i] = newLRUShard[K, V](strconv.Itoa(i), perShard, onEvicted)
+	}
+	return c
+}
+
+func (c *ConcurrentLRU[K, V]) shardFor(key K) *lruShard[K, V] {
+	return c.shards[hashKey(key)%uint64(len(c.shards))]
+}
+
+// Add adds a value to the cache.
+func (c *ConcurrentLRU[K, V]) Add(key K, value V) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		s.evictList.MoveToFront(e)
+		e.Value = evictable[K, V]{key: key, value: value}
+		return
+	}
+
+	e := s.evictList.PushFront(evictable[K, V]{key: key, value: value})
+	s.items[key] = e
+
+	if s.maxEntries != 0 && s.evictList.Len() > s.maxEntries {
+		s.removeOldest(c.metrics)
+	}
+}
+
+// Get looks up a key's value from the cache.
+func (c *ConcurrentLRU[K, V]) Get(key K) (value V, ok bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		s.evictList.MoveToFront(e)
+		c.metrics.hits.WithLabelValues(s.id).Inc()
+		return e.Value.(evictable[K, V]).value, true
+	}
+	c.metrics.misses.WithLabelValues(s.id).Inc()
+	return
+}
+
+// Peek returns the key value (or undefined if not found) without updating the "recently used"-ness of the key.
+func (c *ConcurrentLRU[K, V]) Peek(key K) (value V, ok bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		return e.Value.(evictable[K, V]).value, true
+	}
+	return
+}
+
+// Remove removes the provided key from the cache.
+func (c *ConcurrentLRU[K, V]) Remove(key K) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		s.removeElement(e, c.metrics)
+	}
+}
+
+// removeOldest removes the oldest item from the shard. s.mu must be held.
+func (s *lruShard[K, V]) removeOldest(m *concurrentMetrics) {
+	e := s.evictList.Back()
+	if e != nil {
+		s.removeElement(e, m)
+	}
+}
+
+// removeElement removes a given list element from the shard. s.mu must be
+// held.
+func (s *lruShard[K, V]) removeElement(e *list.Element, m *concurrentMetrics) {
+	s.evictList.Remove(e)
+	kv := e.Value.(evictable[K, V])
+	delete(s.items, kv.key)
+	if s.onEvicted != nil {
+		s.onEvicted(kv.key, kv.value)
+	}
+	m.evictions.WithLabelValues(s.id).Inc()
+}
+
+// Purge is used to completely clear the cache.
+func (c *ConcurrentLRU[K, V]) Purge() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		// Route through removeElement so evictions stay in lockstep with
+		// every other removal path instead of duplicating that bookkeeping
+		// here.
+		for _, e := range s.items {
+			s.removeElement(e, c.metrics)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close closes the cache using registered closer.
+func (c *ConcurrentLRU[K, V]) Close() error {
+	c.Purge()
+	if c.closer != nil {
+		return c.closer()
+	}
+	return nil
+}
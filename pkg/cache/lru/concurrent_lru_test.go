@@ -0,0 +1,135 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func concurrentCounterTotal(t *testing.T, c *prometheus.CounterVec) float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 64)
+	c.Collect(ch)
+	close(ch)
+
+	var total float64
+	for metric := range ch {
+		m := &dto.Metric{}
+		require.NoError(t, metric.Write(m))
+		total += m.GetCounter().GetValue()
+	}
+	return total
+}
+
+func TestConcurrentLRUBasic(t *testing.T) {
+	c := NewConcurrentLRU[string, int](prometheus.NewRegistry(), 0, 4, nil)
+
+	c.Add("a", 1)
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	c.Remove("a")
+	_, ok = c.Get("a")
+	require.False(t, ok)
+}
+
+func TestConcurrentLRUEvictsPerShardCapacity(t *testing.T) {
+	var mu sync.Mutex
+	var evicted int
+
+	c := NewConcurrentLRU[int, int](prometheus.NewRegistry(), 2, 1, func(_ int, _ int) {
+		mu.Lock()
+		evicted++
+		mu.Unlock()
+	})
+
+	for i := 0; i < 10; i++ {
+		c.Add(i, i)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Greater(t, evicted, 0)
+}
+
+func TestConcurrentLRUConcurrentAccess(t *testing.T) {
+	c := NewConcurrentLRU[int, int](prometheus.NewRegistry(), 1000, 8, nil)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := g*100 + i
+				c.Add(key, key)
+				c.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentLRUPurge(t *testing.T) {
+	c := NewConcurrentLRU[string, int](prometheus.NewRegistry(), 0, 4, nil)
+	for i := 0; i < 20; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+
+	c.Purge()
+
+	for i := 0; i < 20; i++ {
+		_, ok := c.Get(strconv.Itoa(i))
+		require.False(t, ok)
+	}
+}
+
+func TestConcurrentLRUPurgeKeepsMetricsConsistent(t *testing.T) {
+	c := NewConcurrentLRU[int, int](prometheus.NewRegistry(), 0, 4, nil)
+	for i := 0; i < 12; i++ {
+		c.Add(i, i)
+	}
+
+	c.Purge()
+
+	require.InDelta(t, 12, concurrentCounterTotal(t, c.metrics.evictions), 0)
+}
+
+// TestConcurrentLRUSmallMaxEntriesClampsShards verifies that a small
+// maxEntries on a high-shard-count host doesn't silently blow up the
+// effective total capacity to shards*1.
+func TestConcurrentLRUSmallMaxEntriesClampsShards(t *testing.T) {
+	const maxEntries = 8
+
+	c := NewConcurrentLRU[int, int](prometheus.NewRegistry(), maxEntries, 1000, nil)
+	require.LessOrEqual(t, len(c.shards), maxEntries)
+
+	for i := 0; i < 10*maxEntries; i++ {
+		c.Add(i, i)
+	}
+
+	var total int
+	for _, s := range c.shards {
+		total += s.evictList.Len()
+	}
+	require.LessOrEqual(t, total, maxEntries)
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// hashKey derives a 64-bit hash for an arbitrary comparable key. Generics
+// don't give us access to the key's underlying bytes, so we fall back to
+// hashing its default string representation. That's good enough for our
+// purposes here: spreading keys across shards and sketch buckets, not
+// cryptographic uniqueness.
+func hashKey[K comparable](key K) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v", key) //nolint:errcheck
+	return h.Sum64()
+}
@@ -16,30 +16,129 @@ package lru
 
 import (
 	"container/list"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// EvictReason describes why an entry left the cache, so callers and
+// metrics can tell routine TTL churn apart from capacity or weight
+// pressure.
+type EvictReason int
+
+const (
+	// ReasonManual is used when an entry is removed explicitly, via
+	// Remove or Purge.
+	ReasonManual EvictReason = iota
+	// ReasonCapacity is used when an entry is evicted to keep the cache
+	// at or under maxEntries.
+	ReasonCapacity
+	// ReasonWeight is used when an entry is evicted to keep the cache at
+	// or under maxBytes.
+	ReasonWeight
+	// ReasonExpired is used when an entry is evicted because its TTL
+	// elapsed.
+	ReasonExpired
+)
+
+// String implements fmt.Stringer, and is used as the "reason" metric
+// label value.
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonWeight:
+		return "weight"
+	case ReasonExpired:
+		return "expired"
+	case ReasonManual:
+		fallthrough
+	default:
+		return "manual"
+	}
+}
+
 type evictable[K comparable, V any] struct {
 	key   K
 	value V
+
+	weight int64
+	// expiresAt is the zero time.Time when the entry has no TTL.
+	expiresAt time.Time
 }
 
 type LRUWithEvict[K comparable, V any] struct {
+	// mu guards every field below. It mainly exists so that the
+	// background TTL sweeper can run safely alongside the foreground
+	// Add/Get/Peek/Remove/Purge calls; LRUWithEvict otherwise makes no
+	// promises about concurrent use from multiple goroutines.
+	mu sync.Mutex
+
 	metrics *metrics
 	closer  func() error
 
-	maxEntries int // Zero means no limit.
-	onEvicted  func(K, V)
+	maxEntries      int // Zero means no limit.
+	onEvicted       func(K, V)
+	onEvictedReason func(K, V, EvictReason)
+
+	ttl       time.Duration
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+
+	weigher     func(K, V) int64
+	maxBytes    int64 // Zero means no limit.
+	totalWeight int64
 
 	evictList *list.List
 	items     map[K]*list.Element
 }
 
+// Option configures optional behaviour of a LRUWithEvict, set via
+// NewWithEvict.
+type Option[K comparable, V any] func(*LRUWithEvict[K, V])
+
+// WithTTL makes entries expire d after they were last added or updated.
+// Expiration is lazy: an expired entry is reclaimed the next time it's
+// looked up via Get or Peek, or by a background sweeper goroutine that
+// runs every d so that expired entries don't linger in memory until
+// they're looked up again. The sweeper is stopped by Close.
+func WithTTL[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(c *LRUWithEvict[K, V]) {
+		c.ttl = d
+	}
+}
+
+// WithWeigher sets the function used to compute an entry's weight, e.g.
+// its approximate memory footprint. It has no effect unless paired with
+// WithMaxBytes.
+func WithWeigher[K comparable, V any](weigher func(K, V) int64) Option[K, V] {
+	return func(c *LRUWithEvict[K, V]) {
+		c.weigher = weigher
+	}
+}
+
+// WithMaxBytes bounds the cache by the running sum of entry weights
+// reported by the function passed to WithWeigher, evicting the oldest
+// entries on Add until the sum is back under n.
+func WithMaxBytes[K comparable, V any](n int64) Option[K, V] {
+	return func(c *LRUWithEvict[K, V]) {
+		c.maxBytes = n
+	}
+}
+
+// WithOnEvictedReason sets a callback invoked with the reason for every
+// eviction, in addition to onEvicted.
+func WithOnEvictedReason[K comparable, V any](f func(K, V, EvictReason)) Option[K, V] {
+	return func(c *LRUWithEvict[K, V]) {
+		c.onEvictedReason = f
+	}
+}
+
 // NewWithEvict returns a new cache with the provided maximum items count.
-func NewWithEvict[K comparable, V any](reg prometheus.Registerer, maxEntries int, onEvicted func(K, V)) *LRUWithEvict[K, V] {
+func NewWithEvict[K comparable, V any](reg prometheus.Registerer, maxEntries int, onEvicted func(K, V), opts ...Option[K, V]) *LRUWithEvict[K, V] {
 	m := newMetrics(reg)
-	return &LRUWithEvict[K, V]{
+	c := &LRUWithEvict[K, V]{
 		metrics: m,
 		closer:  m.unregister,
 
@@ -49,85 +148,201 @@ func NewWithEvict[K comparable, V any](reg prometheus.Registerer, maxEntries int
 		evictList: list.New(),
 		items:     make(map[K]*list.Element),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.ttl > 0 {
+		c.sweepStop = make(chan struct{})
+		c.sweepDone = make(chan struct{})
+		go c.sweep()
+	}
+
+	return c
 }
 
 // Add adds a value to the cache.
 func (c *LRUWithEvict[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var weight int64
+	if c.weigher != nil {
+		weight = c.weigher(key, value)
+	}
+
 	if e, ok := c.items[key]; ok {
+		old := e.Value.(evictable[K, V])
+		c.totalWeight += weight - old.weight
+		e.Value = c.newEvictable(key, value, weight)
 		c.evictList.MoveToFront(e)
-		e.Value = evictable[K, V]{key, value}
+		c.enforceLimits()
 		return
 	}
 
-	e := c.evictList.PushFront(evictable[K, V]{key, value})
+	e := c.evictList.PushFront(c.newEvictable(key, value, weight))
 	c.items[key] = e
+	c.totalWeight += weight
+
+	c.enforceLimits()
+}
+
+func (c *LRUWithEvict[K, V]) newEvictable(key K, value V, weight int64) evictable[K, V] {
+	e := evictable[K, V]{key: key, value: value, weight: weight}
+	if c.ttl > 0 {
+		e.expiresAt = time.Now().Add(c.ttl)
+	}
+	return e
+}
 
-	if c.maxEntries != 0 && c.evictList.Len() > c.maxEntries {
-		c.removeOldest()
+// enforceLimits evicts oldest entries until the cache is back under both
+// maxEntries and maxBytes. c.mu must be held.
+func (c *LRUWithEvict[K, V]) enforceLimits() {
+	for c.maxEntries != 0 && c.evictList.Len() > c.maxEntries {
+		c.removeOldest(ReasonCapacity)
+	}
+	for c.maxBytes != 0 && c.totalWeight > c.maxBytes && c.evictList.Len() > 0 {
+		c.removeOldest(ReasonWeight)
 	}
 }
 
 // Get looks up a key's value from the cache.
 func (c *LRUWithEvict[K, V]) Get(key K) (value V, ok bool) {
-	if e, ok := c.items[key]; ok {
-		c.evictList.MoveToFront(e)
-		c.metrics.hits.Inc()
-		return e.Value.(evictable[K, V]).value, true
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.metrics.misses.Inc()
+		return value, false
 	}
-	c.metrics.misses.Inc()
-	return
+
+	kv := e.Value.(evictable[K, V])
+	if c.expired(kv) {
+		c.removeElement(e, ReasonExpired)
+		c.metrics.misses.Inc()
+		return value, false
+	}
+
+	c.evictList.MoveToFront(e)
+	c.metrics.hits.Inc()
+	return kv.value, true
 }
 
 // Peek returns the key value (or undefined if not found) without updating the "recently used"-ness of the key.
 func (c *LRUWithEvict[K, V]) Peek(key K) (value V, ok bool) {
-	if e, ok := c.items[key]; ok {
-		return e.Value.(evictable[K, V]).value, true
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return value, false
+	}
+
+	kv := e.Value.(evictable[K, V])
+	if c.expired(kv) {
+		c.removeElement(e, ReasonExpired)
+		return value, false
 	}
-	return
+	return kv.value, true
+}
+
+func (c *LRUWithEvict[K, V]) expired(kv evictable[K, V]) bool {
+	return !kv.expiresAt.IsZero() && time.Now().After(kv.expiresAt)
 }
 
 // Remove removes the provided key from the cache.
 func (c *LRUWithEvict[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if e, ok := c.items[key]; ok {
-		c.removeElement(e)
+		c.removeElement(e, ReasonManual)
 	}
 }
 
-// removeOldest removes the oldest item from the cache.
-func (c *LRUWithEvict[K, V]) removeOldest() {
+// removeOldest removes the oldest item from the cache. c.mu must be held.
+func (c *LRUWithEvict[K, V]) removeOldest(reason EvictReason) {
 	e := c.evictList.Back()
 	if e != nil {
-		c.removeElement(e)
+		c.removeElement(e, reason)
 	}
 }
 
 // removeElement is used to remove a given list element from the cache.
-func (c *LRUWithEvict[K, V]) removeElement(e *list.Element) {
+// c.mu must be held.
+func (c *LRUWithEvict[K, V]) removeElement(e *list.Element, reason EvictReason) {
 	c.evictList.Remove(e)
 	kv := e.Value.(evictable[K, V])
 	delete(c.items, kv.key)
+	c.totalWeight -= kv.weight
+
 	if c.onEvicted != nil {
 		c.onEvicted(kv.key, kv.value)
 	}
+	if c.onEvictedReason != nil {
+		c.onEvictedReason(kv.key, kv.value, reason)
+	}
 	c.metrics.evictions.Inc()
+	c.metrics.evictionsByReason.WithLabelValues(reason.String()).Inc()
+}
+
+// sweep periodically reclaims expired entries in the background, so that
+// idle entries don't linger in memory until they're next looked up. It
+// runs until sweepStop is closed.
+func (c *LRUWithEvict[K, V]) sweep() {
+	defer close(c.sweepDone)
+
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.sweepStop:
+			return
+		case <-ticker.C:
+			c.sweepExpired()
+		}
+	}
+}
+
+func (c *LRUWithEvict[K, V]) sweepExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.evictList.Front(); e != nil; {
+		next := e.Next()
+		if c.expired(e.Value.(evictable[K, V])) {
+			c.removeElement(e, ReasonExpired)
+		}
+		e = next
+	}
 }
 
 // Purge is used to completely clear the cache.
 func (c *LRUWithEvict[K, V]) Purge() {
-	for k, e := range c.items {
-		if c.onEvicted != nil {
-			c.onEvicted(k, e.Value.(evictable[K, V]).value)
-		}
-		delete(c.items, k)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Route through removeElement so evictions/evictionsByReason and
+	// totalWeight stay in lockstep with every other removal path instead
+	// of duplicating that bookkeeping here.
+	for _, e := range c.items {
+		c.removeElement(e, ReasonManual)
 	}
-	c.evictList.Init()
 }
 
 // Close closes the cache using registered closer.
 func (c *LRUWithEvict[K, V]) Close() error {
+	if c.sweepStop != nil {
+		close(c.sweepStop)
+		<-c.sweepDone
+	}
+
 	c.Purge()
 	if c.closer != nil {
 		return c.closer()
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func counterValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	close(ch)
+
+	m := &dto.Metric{}
+	require.NoError(t, (<-ch).Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func TestLRUWithEvictCapacity(t *testing.T) {
+	var evicted []int
+	c := NewWithEvict[int, string](prometheus.NewRegistry(), 2, func(k int, _ string) {
+		evicted = append(evicted, k)
+	})
+
+	c.Add(1, "a")
+	c.Add(2, "b")
+	c.Add(3, "c")
+
+	require.Equal(t, []int{1}, evicted)
+	_, ok := c.Get(1)
+	require.False(t, ok)
+	v, ok := c.Get(3)
+	require.True(t, ok)
+	require.Equal(t, "c", v)
+}
+
+func TestLRUWithEvictTTL(t *testing.T) {
+	c := NewWithEvict[int, string](prometheus.NewRegistry(), 0, nil, WithTTL[int, string](time.Millisecond))
+	defer c.Close()
+
+	c.Add(1, "a")
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get(1)
+	require.False(t, ok, "expired entry should no longer be returned by Get")
+}
+
+func TestLRUWithEvictMaxBytes(t *testing.T) {
+	var evictedReasons []EvictReason
+	c := NewWithEvict[int, string](prometheus.NewRegistry(), 0, nil,
+		WithWeigher[int, string](func(_ int, v string) int64 { return int64(len(v)) }),
+		WithMaxBytes[int, string](5),
+		WithOnEvictedReason[int, string](func(_ int, _ string, r EvictReason) {
+			evictedReasons = append(evictedReasons, r)
+		}),
+	)
+
+	c.Add(1, "abc")
+	c.Add(2, "abc")
+
+	require.Equal(t, []EvictReason{ReasonWeight}, evictedReasons)
+	_, ok := c.Get(1)
+	require.False(t, ok)
+}
+
+func TestLRUWithEvictPurgeKeepsMetricsConsistent(t *testing.T) {
+	c := NewWithEvict[int, string](prometheus.NewRegistry(), 0, nil)
+
+	c.Add(1, "a")
+	c.Add(2, "b")
+	c.Add(3, "c")
+
+	c.Purge()
+
+	require.InDelta(t, 3, counterValue(t, c.metrics.evictions), 0)
+	require.Equal(t, 0, c.evictList.Len())
+	require.Equal(t, 0, len(c.items))
+}
+
+func TestLRUWithEvictRemove(t *testing.T) {
+	c := NewWithEvict[int, string](prometheus.NewRegistry(), 0, nil)
+
+	c.Add(1, "a")
+	c.Remove(1)
+
+	_, ok := c.Get(1)
+	require.False(t, ok)
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics holds the Prometheus collectors shared by a single LRUWithEvict
+// instance.
+type metrics struct {
+	reg prometheus.Registerer
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+
+	// evictionsByReason breaks down evictions by why the entry was
+	// removed, so operators can tell TTL churn apart from capacity or
+	// weight pressure.
+	evictionsByReason *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	return &metrics{
+		reg: reg,
+
+		hits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_lru_hits_total",
+			Help: "Number of cache lookups that found a value.",
+		}),
+		misses: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_lru_misses_total",
+			Help: "Number of cache lookups that did not find a value.",
+		}),
+		evictions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_lru_evictions_total",
+			Help: "Number of entries evicted from the cache.",
+		}),
+		evictionsByReason: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_agent_lru_evictions_reason_total",
+			Help: "Number of entries evicted from the cache, broken down by reason.",
+		}, []string{"reason"}),
+	}
+}
+
+// unregister removes the metrics from the registerer they were created
+// with, if any.
+func (m *metrics) unregister() error {
+	if m.reg == nil {
+		return nil
+	}
+	m.reg.Unregister(m.hits)
+	m.reg.Unregister(m.misses)
+	m.reg.Unregister(m.evictions)
+	m.reg.Unregister(m.evictionsByReason)
+	return nil
+}
+
+// concurrentMetrics holds the Prometheus collectors shared by a single
+// ConcurrentLRU instance. Unlike metrics, hits, misses and evictions are
+// all labelled by shard; the shard label is always present and cannot be
+// turned off.
+type concurrentMetrics struct {
+	reg prometheus.Registerer
+
+	hits      *prometheus.CounterVec
+	misses    *prometheus.CounterVec
+	evictions *prometheus.CounterVec
+}
+
+func newConcurrentMetrics(reg prometheus.Registerer) *concurrentMetrics {
+	return &concurrentMetrics{
+		reg: reg,
+
+		hits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_agent_concurrent_lru_hits_total",
+			Help: "Number of cache lookups that found a value.",
+		}, []string{"shard"}),
+		misses: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_agent_concurrent_lru_misses_total",
+			Help: "Number of cache lookups that did not find a value.",
+		}, []string{"shard"}),
+		evictions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_agent_concurrent_lru_evictions_total",
+			Help: "Number of entries evicted from the cache.",
+		}, []string{"shard"}),
+	}
+}
+
+func (m *concurrentMetrics) unregister() error {
+	if m.reg == nil {
+		return nil
+	}
+	m.reg.Unregister(m.hits)
+	m.reg.Unregister(m.misses)
+	m.reg.Unregister(m.evictions)
+	return nil
+}
+
+// tinyLFUMetrics holds the Prometheus collectors for a single TinyLFU
+// admission-filtered cache.
+type tinyLFUMetrics struct {
+	reg prometheus.Registerer
+
+	hits         prometheus.Counter
+	misses       prometheus.Counter
+	admissions   *prometheus.CounterVec
+	sketchResets prometheus.Counter
+}
+
+func newTinyLFUMetrics(reg prometheus.Registerer) *tinyLFUMetrics {
+	return &tinyLFUMetrics{
+		reg: reg,
+
+		hits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_tinylfu_hits_total",
+			Help: "Number of cache lookups that found a value.",
+		}),
+		misses: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_tinylfu_misses_total",
+			Help: "Number of cache lookups that did not find a value.",
+		}),
+		admissions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_agent_tinylfu_admissions_total",
+			Help: "Number of window-evicted candidates considered for admission into the main cache, by decision.",
+		}, []string{"decision"}),
+		sketchResets: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_agent_tinylfu_sketch_resets_total",
+			Help: "Number of times the Count-Min Sketch frequency counters were halved to age out stale history.",
+		}),
+	}
+}
+
+func (m *tinyLFUMetrics) unregister() error {
+	if m.reg == nil {
+		return nil
+	}
+	m.reg.Unregister(m.hits)
+	m.reg.Unregister(m.misses)
+	m.reg.Unregister(m.admissions)
+	m.reg.Unregister(m.sketchResets)
+	return nil
+}
@@ -0,0 +1,424 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//nolint:forcetypeassert,nonamedreturns
+package lru
+
+import (
+	"container/list"
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cmsDepth is the number of independent hash functions (and therefore
+// rows) used by the Count-Min Sketch.
+const cmsDepth = 4
+
+// cmsMaxCount is the saturation point of each 4-bit counter.
+const cmsMaxCount = 15
+
+// countMinSketch is a 4-bit Count-Min Sketch used to estimate how
+// frequently a key has been seen recently, without keeping an exact
+// per-key counter. Counters are periodically halved ("aged") so that the
+// estimate reflects recent access patterns rather than all of history.
+type countMinSketch struct {
+	width uint64
+	rows  [cmsDepth][]uint8
+
+	increments int64
+	resetEvery int64
+
+	onReset func()
+}
+
+func newCountMinSketch(capacity int, onReset func()) *countMinSketch {
+	width := nextPowerOfTwo(uint64(capacity) * 10)
+	if width == 0 {
+		width = 1
+	}
+
+	s := &countMinSketch{
+		width:      width,
+		resetEvery: int64(capacity) * 10,
+		onReset:    onReset,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	if s.resetEvery <= 0 {
+		s.resetEvery = 1
+	}
+	return s
+}
+
+func nextPowerOfTwo(n uint64) uint64 {
+	if n == 0 {
+		return 1
+	}
+	n--
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	n |= n >> 32
+	return n + 1
+}
+
+// rowHash derives the cmsDepth row hashes from a single 64-bit key hash,
+// instead of hashing the key cmsDepth times.
+func (s *countMinSketch) rowHash(h uint64, row int) uint64 {
+	mixed := h ^ (uint64(row+1) * 0x9E3779B97F4A7C15)
+	mixed ^= mixed >> 33
+	mixed *= 0xff51afd7ed558ccd
+	mixed ^= mixed >> 33
+	return mixed & (s.width - 1)
+}
+
+// Add records one occurrence of the key hashing to h.
+func (s *countMinSketch) Add(h uint64) {
+	for row := 0; row < cmsDepth; row++ {
+		idx := s.rowHash(h, row)
+		if s.rows[row][idx] < cmsMaxCount {
+			s.rows[row][idx]++
+		}
+	}
+
+	s.increments++
+	if s.increments >= s.resetEvery {
+		s.age()
+	}
+}
+
+// Estimate returns the minimum counter seen across all rows for h, an
+// upper bound on how often the key has occurred recently.
+func (s *countMinSketch) Estimate(h uint64) uint8 {
+	min := uint8(cmsMaxCount)
+	for row := 0; row < cmsDepth; row++ {
+		if v := s.rows[row][s.rowHash(h, row)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// age halves every counter, so that old, stale frequency history decays
+// relative to recent activity.
+func (s *countMinSketch) age() {
+	for row := range s.rows {
+		for i, v := range s.rows[row] {
+			s.rows[row][i] = v / 2
+		}
+	}
+	s.increments = 0
+	if s.onReset != nil {
+		s.onReset()
+	}
+}
+
+type tinylfuSegment int
+
+const (
+	segWindow tinylfuSegment = iota
+	segProbationary
+	segProtected
+)
+
+type tinylfuEntry[K comparable, V any] struct {
+	key   K
+	value V
+	hash  uint64
+}
+
+type tinylfuLocation[K comparable, V any] struct {
+	seg tinylfuSegment
+	el  *list.Element
+}
+
+// TinyLFU is a cache that uses the W-TinyLFU admission policy: new entries
+// land in a small window LRU; entries evicted from the window are only
+// admitted into the main cache (a segmented LRU with protected and
+// probationary segments) if they're estimated, via a Count-Min Sketch, to
+// be accessed more frequently than the main cache's own eviction victim.
+// This protects the cache from being thrashed by long scans over data
+// that's only ever touched once, which is typical of one-shot binaries in
+// a profiling workload.
+type TinyLFU[K comparable, V any] struct {
+	mu sync.Mutex
+
+	metrics   *tinyLFUMetrics
+	closer    func() error
+	onEvicted func(K, V)
+
+	sketch *countMinSketch
+
+	window    *list.List
+	windowCap int
+
+	// mainCap is the combined capacity of the protected and probationary
+	// segments, i.e. everything other than the window.
+	mainCap int
+
+	protected    *list.List
+	protectedCap int
+
+	probationary *list.List
+
+	items map[K]tinylfuLocation[K, V]
+}
+
+// noLimit is used for windowCap/mainCap/protectedCap when the cache is
+// unbounded, matching the maxEntries == 0 "no limit" convention used by
+// NewWithEvict and NewConcurrentLRU elsewhere in this package.
+const noLimit = math.MaxInt
+
+// defaultUnboundedSketchCapacity sizes the Count-Min Sketch when
+// maxEntries == 0. Admission decisions never kick in on an unbounded
+// cache, so the sketch's precision doesn't affect correctness; this just
+// keeps frequency estimates reasonably granular.
+const defaultUnboundedSketchCapacity = 1 << 16
+
+// NewTinyLFU returns a new W-TinyLFU admission-filtered cache bounded at
+// maxEntries. As with NewWithEvict and NewConcurrentLRU, maxEntries == 0
+// means unbounded. The window and the main cache each hold at least one
+// entry, so for maxEntries of 1 or 2 the cache can hold up to 3 entries.
+func NewTinyLFU[K comparable, V any](reg prometheus.Registerer, maxEntries int, onEvicted func(K, V)) *TinyLFU[K, V] {
+	windowCap, mainCap, protectedCap := noLimit, noLimit, noLimit
+	sketchCapacity := maxEntries
+
+	if maxEntries > 0 {
+		windowCap = maxEntries / 100
+		if windowCap < 1 {
+			windowCap = 1
+		}
+		mainCap = maxEntries - windowCap
+		if mainCap < 1 {
+			mainCap = 1
+		}
+		protectedCap = mainCap * 20 / 100
+		if protectedCap < 1 {
+			protectedCap = 1
+		}
+	} else {
+		sketchCapacity = defaultUnboundedSketchCapacity
+	}
+
+	m := newTinyLFUMetrics(reg)
+	t := &TinyLFU[K, V]{
+		metrics:   m,
+		closer:    m.unregister,
+		onEvicted: onEvicted,
+
+		window:    list.New(),
+		windowCap: windowCap,
+
+		mainCap: mainCap,
+
+		protected:    list.New(),
+		protectedCap: protectedCap,
+
+		probationary: list.New(),
+
+		items: make(map[K]tinylfuLocation[K, V]),
+	}
+	t.sketch = newCountMinSketch(sketchCapacity, func() { m.sketchResets.Inc() })
+	return t
+}
+
+// recordHit moves an existing entry to the front of its segment and
+// records an access against the frequency sketch, promoting a
+// probationary hit to protected. It's shared by Get and the existing-key
+// path of Add, so that refreshing a value (e.g. a symbol cache entry
+// invalidated and repopulated in place) keeps its recency and frequency
+// exactly as if it had been read, instead of looking as cold as an entry
+// nobody has touched since insertion. t.mu must be held.
+func (t *TinyLFU[K, V]) recordHit(loc tinylfuLocation[K, V], entry tinylfuEntry[K, V]) {
+	t.sketch.Add(entry.hash)
+
+	switch loc.seg {
+	case segWindow:
+		t.window.MoveToFront(loc.el)
+	case segProtected:
+		t.protected.MoveToFront(loc.el)
+	case segProbationary:
+		t.probationary.Remove(loc.el)
+		t.insertProtected(entry)
+	}
+}
+
+// Add adds a value to the cache. New keys always enter via the window
+// segment; whether they ultimately displace an existing main-cache entry
+// is decided by the admission filter once they're evicted from the
+// window.
+func (t *TinyLFU[K, V]) Add(key K, value V) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if loc, ok := t.items[key]; ok {
+		entry := loc.el.Value.(tinylfuEntry[K, V])
+		entry.value = value
+		loc.el.Value = entry
+		t.recordHit(loc, entry)
+		return
+	}
+
+	h := hashKey(key)
+	t.sketch.Add(h)
+	el := t.window.PushFront(tinylfuEntry[K, V]{key: key, value: value, hash: h})
+	t.items[key] = tinylfuLocation[K, V]{seg: segWindow, el: el}
+
+	for t.window.Len() > t.windowCap {
+		back := t.window.Back()
+		entry := back.Value.(tinylfuEntry[K, V])
+		t.window.Remove(back)
+		delete(t.items, entry.key)
+		t.admit(entry)
+	}
+}
+
+// admit decides whether a window-evicted candidate should be promoted
+// into the main cache. If the main cache has spare room it's admitted
+// unconditionally; otherwise it's only admitted if the Count-Min Sketch
+// estimates it's accessed more frequently than the probationary segment's
+// own eviction victim. t.mu must be held.
+func (t *TinyLFU[K, V]) admit(candidate tinylfuEntry[K, V]) {
+	if t.probationary.Len()+t.protected.Len() < t.mainCap {
+		t.insertProbationary(candidate)
+		t.metrics.admissions.WithLabelValues("admit").Inc()
+		return
+	}
+
+	victimEl := t.probationary.Back()
+	if victimEl == nil {
+		t.insertProbationary(candidate)
+		t.metrics.admissions.WithLabelValues("admit").Inc()
+		return
+	}
+
+	victim := victimEl.Value.(tinylfuEntry[K, V])
+	if t.sketch.Estimate(candidate.hash) > t.sketch.Estimate(victim.hash) {
+		t.probationary.Remove(victimEl)
+		delete(t.items, victim.key)
+		if t.onEvicted != nil {
+			t.onEvicted(victim.key, victim.value)
+		}
+
+		t.insertProbationary(candidate)
+		t.metrics.admissions.WithLabelValues("admit").Inc()
+		return
+	}
+
+	t.metrics.admissions.WithLabelValues("reject").Inc()
+	if t.onEvicted != nil {
+		t.onEvicted(candidate.key, candidate.value)
+	}
+}
+
+func (t *TinyLFU[K, V]) insertProbationary(entry tinylfuEntry[K, V]) {
+	el := t.probationary.PushFront(entry)
+	t.items[entry.key] = tinylfuLocation[K, V]{seg: segProbationary, el: el}
+}
+
+// insertProtected promotes entry into the protected segment, demoting its
+// own oldest entry back down to probationary if that pushes protected over
+// its cap.
+func (t *TinyLFU[K, V]) insertProtected(entry tinylfuEntry[K, V]) {
+	el := t.protected.PushFront(entry)
+	t.items[entry.key] = tinylfuLocation[K, V]{seg: segProtected, el: el}
+
+	if t.protected.Len() > t.protectedCap {
+		back := t.protected.Back()
+		demoted := back.Value.(tinylfuEntry[K, V])
+		t.protected.Remove(back)
+		t.insertProbationary(demoted)
+	}
+}
+
+// Get looks up a key's value from the cache. A hit in probationary
+// promotes the entry to protected; a hit anywhere also records an
+// occurrence in the Count-Min Sketch.
+func (t *TinyLFU[K, V]) Get(key K) (value V, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loc, ok := t.items[key]
+	if !ok {
+		t.metrics.misses.Inc()
+		return value, false
+	}
+
+	entry := loc.el.Value.(tinylfuEntry[K, V])
+	t.metrics.hits.Inc()
+	t.recordHit(loc, entry)
+	return entry.value, true
+}
+
+// Peek returns the key value (or undefined if not found) without updating the "recently used"-ness of the key.
+func (t *TinyLFU[K, V]) Peek(key K) (value V, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loc, ok := t.items[key]
+	if !ok {
+		return value, false
+	}
+	return loc.el.Value.(tinylfuEntry[K, V]).value, true
+}
+
+// Remove removes the provided key from the cache.
+func (t *TinyLFU[K, V]) Remove(key K) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loc, ok := t.items[key]
+	if !ok {
+		return
+	}
+
+	switch loc.seg {
+	case segWindow:
+		t.window.Remove(loc.el)
+	case segProtected:
+		t.protected.Remove(loc.el)
+	case segProbationary:
+		t.probationary.Remove(loc.el)
+	}
+	delete(t.items, key)
+}
+
+// Purge is used to completely clear the cache.
+func (t *TinyLFU[K, V]) Purge() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.onEvicted != nil {
+		for k, loc := range t.items {
+			t.onEvicted(k, loc.el.Value.(tinylfuEntry[K, V]).value)
+		}
+	}
+	t.items = make(map[K]tinylfuLocation[K, V])
+	t.window.Init()
+	t.protected.Init()
+	t.probationary.Init()
+}
+
+// Close closes the cache using registered closer.
+func (t *TinyLFU[K, V]) Close() error {
+	t.Purge()
+	if t.closer != nil {
+		return t.closer()
+	}
+	return nil
+}
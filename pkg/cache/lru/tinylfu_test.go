@@ -0,0 +1,134 @@
+// Copyright 2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lru
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTinyLFUBasic(t *testing.T) {
+	c := NewTinyLFU[string, int](prometheus.NewRegistry(), 100, nil)
+
+	c.Add("a", 1)
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	c.Remove("a")
+	_, ok = c.Get("a")
+	require.False(t, ok)
+}
+
+func TestTinyLFUUnbounded(t *testing.T) {
+	c := NewTinyLFU[int, int](prometheus.NewRegistry(), 0, nil)
+
+	for i := 0; i < 10_000; i++ {
+		c.Add(i, i)
+	}
+
+	for i := 0; i < 10_000; i++ {
+		v, ok := c.Get(i)
+		require.True(t, ok, "unbounded cache must never evict")
+		require.Equal(t, i, v)
+	}
+}
+
+// TestTinyLFUAddRefreshKeepsRecencyAndFrequency verifies that updating the
+// value of a key already in the cache (e.g. a symbol cache entry refreshed
+// in place) bumps its recency and the frequency sketch exactly like a Get
+// would, instead of leaving it looking as cold as an entry nobody has
+// touched since insertion.
+func TestTinyLFUAddRefreshKeepsRecencyAndFrequency(t *testing.T) {
+	c := NewTinyLFU[int, int](prometheus.NewRegistry(), 100, nil)
+
+	c.Add(1, 1)
+	// With maxEntries 100 the window holds a single entry, so adding key 2
+	// moves key 1 into the probationary segment.
+	c.Add(2, 2)
+	loc := c.items[1]
+	entry := loc.el.Value.(tinylfuEntry[int, int])
+	before := c.sketch.Estimate(entry.hash)
+
+	c.Add(1, 2)
+
+	loc = c.items[1]
+	entry = loc.el.Value.(tinylfuEntry[int, int])
+	after := c.sketch.Estimate(entry.hash)
+
+	require.Equal(t, 2, entry.value)
+	require.Greater(t, after, before, "refreshing a key's value should record a sketch hit")
+	require.Same(t, c.protected.Front(), loc.el, "refreshing a probationary key should promote it to the front of protected, as a Get would")
+}
+
+func TestTinyLFUAdmissionRejectsColdCandidateOverHotVictim(t *testing.T) {
+	c := NewTinyLFU[int, int](prometheus.NewRegistry(), 1000, nil)
+
+	// Make probationary's hottest key very hot relative to a one-shot
+	// candidate, then drive enough window churn to force an admission
+	// decision.
+	hot := tinylfuEntry[int, int]{key: -1, value: -1, hash: hashKey(-1)}
+	c.insertProbationary(hot)
+	for i := 0; i < 50; i++ {
+		c.sketch.Add(hot.hash)
+	}
+
+	for i := 0; i < c.mainCap+c.windowCap+10; i++ {
+		c.Add(i, i)
+	}
+
+	_, ok := c.Get(-1)
+	require.True(t, ok, "a much hotter probationary entry should survive admission pressure from cold candidates")
+}
+
+func TestTinyLFUPurge(t *testing.T) {
+	c := NewTinyLFU[string, int](prometheus.NewRegistry(), 100, nil)
+	for i := 0; i < 10; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+
+	c.Purge()
+
+	for i := 0; i < 10; i++ {
+		_, ok := c.Get(strconv.Itoa(i))
+		require.False(t, ok)
+	}
+}
+
+func TestCountMinSketchEstimateTracksAdds(t *testing.T) {
+	s := newCountMinSketch(100, nil)
+
+	h := hashKey("k")
+	before := s.Estimate(h)
+	s.Add(h)
+	s.Add(h)
+	after := s.Estimate(h)
+
+	require.Greater(t, after, before)
+}
+
+func TestCountMinSketchAges(t *testing.T) {
+	var resets int
+	s := newCountMinSketch(1, func() { resets++ })
+
+	h := hashKey("k")
+	for i := 0; i < 100; i++ {
+		s.Add(h)
+	}
+
+	require.Greater(t, resets, 0)
+}
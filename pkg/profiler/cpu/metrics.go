@@ -15,6 +15,10 @@
 package cpu
 
 import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
 	bpf "github.com/aquasecurity/libbpfgo"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -120,6 +124,16 @@ var (
 		"Maximum entries in BPF map",
 		[]string{"bpf_map_name"}, nil,
 	)
+	descBPFMapEntries = prometheus.NewDesc(
+		"parca_agent_bpf_map_entries",
+		"Current number of entries in BPF map",
+		[]string{"bpf_map_name"}, nil,
+	)
+	descBPFMapUtilization = prometheus.NewDesc(
+		"parca_agent_bpf_map_utilization_ratio",
+		"Ratio of current entries to max entries for BPF map, useful for alerting before max_entries is hit",
+		[]string{"bpf_map_name"}, nil,
+	)
 	// Native unwinder statistics.
 	//
 	// These error counters help us track how the unwinder is doing. On errors,
@@ -145,6 +159,15 @@ var (
 		"There was an error while unwinding the stack.",
 		[]string{"reason"}, nil,
 	)
+	// descNativeUnwinderDuration is built from a per-CPU, log2-bucketed BPF
+	// histogram of nanoseconds spent inside the native unwinder program,
+	// so it can be correlated with the success/error counters above
+	// without a separate collector.
+	descNativeUnwinderDuration = prometheus.NewDesc(
+		"parca_agent_native_unwinder_duration_seconds",
+		"Time spent inside the native unwinder program.",
+		[]string{"unwinder"}, nil,
+	)
 )
 
 func (c *bpfMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -152,10 +175,13 @@ func (c *bpfMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- descBPFMapKeySize
 	ch <- descBPFMapValueSize
 	ch <- descBPFMapMaxEntries
+	ch <- descBPFMapEntries
+	ch <- descBPFMapUtilization
 
 	ch <- descNativeUnwinderTotalSamples
 	ch <- descNativeUnwinderSuccess
 	ch <- descNativeUnwinderErrors
+	ch <- descNativeUnwinderDuration
 }
 
 func (c *bpfMetricsCollector) Collect(ch chan<- prometheus.Metric) {
@@ -164,9 +190,40 @@ func (c *bpfMetricsCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(descBPFMapKeySize, prometheus.GaugeValue, bpfMetrics.bpfMapKeySize, bpfMetrics.mapName)
 		ch <- prometheus.MustNewConstMetric(descBPFMapValueSize, prometheus.GaugeValue, bpfMetrics.bpfMapValueSize, bpfMetrics.mapName)
 		ch <- prometheus.MustNewConstMetric(descBPFMapMaxEntries, prometheus.GaugeValue, bpfMetrics.bpfMaxEntry, bpfMetrics.mapName)
+
+		entries, err := c.getBPFMapEntries(bpfMetrics.mapName)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to count BPF map entries", "map", bpfMetrics.mapName, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descBPFMapEntries, prometheus.GaugeValue, float64(entries), bpfMetrics.mapName)
+		if bpfMetrics.bpfMaxEntry > 0 {
+			ch <- prometheus.MustNewConstMetric(descBPFMapUtilization, prometheus.GaugeValue, float64(entries)/bpfMetrics.bpfMaxEntry, bpfMetrics.mapName)
+		}
 	}
 
 	c.collectUnwinderStatistics(ch)
+	c.collectUnwinderDuration(ch)
+}
+
+// getBPFMapEntries returns the current number of entries in the named BPF
+// map by walking its keys with bpf_map__get_next_key. This is O(n) in the
+// number of entries, which is acceptable at the low scrape frequency and
+// map sizes we deal with here; BPF_MAP_LOOKUP_BATCH would save syscalls on
+// the kernels that support it for the map type, but libbpfgo doesn't
+// expose it uniformly enough yet to rely on it.
+func (c *bpfMetricsCollector) getBPFMapEntries(name string) (int, error) {
+	m, err := c.m.GetMap(name)
+	if err != nil {
+		return 0, fmt.Errorf("get map %q: %w", name, err)
+	}
+
+	var n int
+	it := m.Iterator()
+	for it.Next() {
+		n++
+	}
+	return n, nil
 }
 
 func (c *bpfMetricsCollector) getUnwinderStats() unwinderStats {
@@ -193,3 +250,90 @@ func (c *bpfMetricsCollector) collectUnwinderStatistics(ch chan<- prometheus.Met
 	ch <- prometheus.MustNewConstMetric(descNativeUnwinderErrors, prometheus.CounterValue, float64(stats.ErrorPcNotCovered), "pc_not_covered")
 	ch <- prometheus.MustNewConstMetric(descNativeUnwinderErrors, prometheus.CounterValue, float64(stats.ErrorUnsupportedJit), "unsupported_jit")
 }
+
+// numUnwinderDurationBuckets is the number of log2 buckets kept by the BPF
+// program. Bucket i covers [2^i, 2^(i+1)) nanoseconds, so the buckets
+// together cover [2^0, 2^32) nanoseconds (up to ~4.3s per sample).
+const numUnwinderDurationBuckets = 32
+
+// unwinderDurationHistogramMapName is the per-CPU array of log2(ns)
+// buckets that the unwinder program bumps on every sample.
+const unwinderDurationHistogramMapName = "unwinder_duration_histogram"
+
+// getUnwinderDurationHistogram reads the per-CPU log2 duration histogram
+// and sums it across CPUs into per-bucket counts. Each bucket i covers
+// [2^i, 2^(i+1)) nanoseconds.
+func (c *bpfMetricsCollector) getUnwinderDurationHistogram() ([]uint64, error) {
+	m, err := c.m.GetMap(unwinderDurationHistogramMapName)
+	if err != nil {
+		return nil, fmt.Errorf("get map %q: %w", unwinderDurationHistogramMapName, err)
+	}
+
+	buckets := make([]uint64, numUnwinderDurationBuckets)
+	for i := range buckets {
+		key := uint32(i)
+		raw, err := m.GetValue(unsafe.Pointer(&key))
+		if err != nil {
+			// The bucket may simply never have been hit yet.
+			continue
+		}
+		buckets[i] = sumPerCPUCounters(raw)
+	}
+	return buckets, nil
+}
+
+// sumPerCPUCounters sums the little-endian uint64 counters packed back to
+// back in a per-CPU BPF map value, one per possible CPU.
+func sumPerCPUCounters(raw []byte) uint64 {
+	const counterSize = 8
+
+	var sum uint64
+	for i := 0; i+counterSize <= len(raw); i += counterSize {
+		sum += binary.LittleEndian.Uint64(raw[i : i+counterSize])
+	}
+	return sum
+}
+
+// durationHistogramFromBuckets turns the per-bucket counts read from the
+// kernel's log2(ns) histogram into the (count, sum, cumulative buckets)
+// triple prometheus.NewConstHistogram expects, with bucket upper bounds
+// converted to seconds. Split out from collectUnwinderDuration so the
+// bucket/cumulative-count math can be unit tested without a BPF module.
+func durationHistogramFromBuckets(buckets []uint64) (count uint64, sumSeconds float64, cumulative map[float64]uint64) {
+	cumulative = make(map[float64]uint64, len(buckets))
+	for i, n := range buckets {
+		count += n
+
+		lowerNanos := float64(uint64(1) << uint(i))
+		upperNanos := float64(uint64(1) << uint(i+1))
+		cumulative[upperNanos/1e9] = count
+		sumSeconds += float64(n) * ((lowerNanos + upperNanos) / 2 / 1e9)
+	}
+	return count, sumSeconds, cumulative
+}
+
+// collectUnwinderDuration exposes the kernel-side log2 duration histogram
+// as parca_agent_native_unwinder_duration_seconds ("native" referring to
+// the native, non-JIT unwinder, as in the sibling metrics above, not to
+// the Prometheus native histogram format). It's built as a classic,
+// explicit-bucket histogram: prometheus.NewConstNativeHistogram would be a
+// better fit for a coarse log2 source histogram, but it's only available
+// from client_golang v1.21.0 onwards, and the sibling histograms in this
+// file are still written against the older NativeHistogramBucketFactor
+// API, so this repo can't be assumed to have it.
+func (c *bpfMetricsCollector) collectUnwinderDuration(ch chan<- prometheus.Metric) {
+	buckets, err := c.getUnwinderDurationHistogram()
+	if err != nil {
+		level.Error(c.logger).Log("msg", "reading unwinder duration histogram failed", "error", err)
+		return
+	}
+
+	count, sumSeconds, cumulative := durationHistogramFromBuckets(buckets)
+
+	m, err := prometheus.NewConstHistogram(descNativeUnwinderDuration, count, sumSeconds, cumulative, "dwarf")
+	if err != nil {
+		level.Error(c.logger).Log("msg", "building unwinder duration histogram failed", "error", err)
+		return
+	}
+	ch <- m
+}
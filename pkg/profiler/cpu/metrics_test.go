@@ -0,0 +1,61 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cpu
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSumPerCPUCounters(t *testing.T) {
+	raw := make([]byte, 8*3)
+	binary.LittleEndian.PutUint64(raw[0:8], 1)
+	binary.LittleEndian.PutUint64(raw[8:16], 2)
+	binary.LittleEndian.PutUint64(raw[16:24], 3)
+
+	require.Equal(t, uint64(6), sumPerCPUCounters(raw))
+}
+
+func TestSumPerCPUCountersEmpty(t *testing.T) {
+	require.Equal(t, uint64(0), sumPerCPUCounters(nil))
+}
+
+func TestDurationHistogramFromBuckets(t *testing.T) {
+	// Bucket 0 covers [2^0, 2^1) ns, bucket 1 covers [2^1, 2^2) ns, and so
+	// on; leave every other bucket at zero.
+	buckets := make([]uint64, numUnwinderDurationBuckets)
+	buckets[0] = 2
+	buckets[1] = 3
+
+	count, sum, cumulative := durationHistogramFromBuckets(buckets)
+
+	require.Equal(t, uint64(5), count)
+	require.InDelta(t, 2*1.5e-9+3*3e-9, sum, 1e-12)
+	require.Equal(t, uint64(2), cumulative[2e-9])
+	require.Equal(t, uint64(5), cumulative[4e-9])
+	// Every higher, untouched bucket boundary still reports the running
+	// (unchanged) cumulative count.
+	require.Equal(t, uint64(5), cumulative[8e-9])
+}
+
+func TestDurationHistogramFromBucketsEmpty(t *testing.T) {
+	count, sum, cumulative := durationHistogramFromBuckets(make([]uint64, numUnwinderDurationBuckets))
+
+	require.Equal(t, uint64(0), count)
+	require.Zero(t, sum)
+	require.Len(t, cumulative, numUnwinderDurationBuckets)
+}